@@ -0,0 +1,173 @@
+// =========================================================
+// Script Name: apt.go
+// Description: Emits a Debian-repository-style Release manifest (plus
+//              clear-signed InRelease and detached Release.gpg) as an
+//              alternative to the TOML output, selected via -format apt.
+// =========================================================
+
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/clearsign"
+)
+
+// aptChecksums holds the four digests APT release files list per entry.
+type aptChecksums struct {
+	RelPath string
+	Size    int64
+	MD5     string
+	SHA1    string
+	SHA256  string
+	SHA512  string
+}
+
+// computeAptChecksums streams every regular file once, computing the four
+// digests an APT Release file needs.
+func computeAptChecksums(inventory DirectoryInventory) ([]aptChecksums, error) {
+	var out []aptChecksums
+
+	for _, fileInfo := range inventory.Files {
+		if fileInfo.IsDir {
+			continue
+		}
+
+		file, err := os.Open(fileInfo.Path)
+		if err != nil {
+			return nil, fmt.Errorf("error opening file %s: %v", fileInfo.Path, err)
+		}
+
+		md5Hasher := md5.New()
+		sha1Hasher := sha1.New()
+		sha256Hasher := sha256.New()
+		sha512Hasher := sha512.New()
+		multi := io.MultiWriter(md5Hasher, sha1Hasher, sha256Hasher, sha512Hasher)
+
+		if _, err := io.Copy(multi, file); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("error reading file %s: %v", fileInfo.Path, err)
+		}
+		file.Close()
+
+		out = append(out, aptChecksums{
+			RelPath: filepath.ToSlash(fileInfo.RelPath),
+			Size:    fileInfo.Size,
+			MD5:     hex.EncodeToString(md5Hasher.Sum(nil)),
+			SHA1:    hex.EncodeToString(sha1Hasher.Sum(nil)),
+			SHA256:  hex.EncodeToString(sha256Hasher.Sum(nil)),
+			SHA512:  hex.EncodeToString(sha512Hasher.Sum(nil)),
+		})
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].RelPath < out[j].RelPath })
+	return out, nil
+}
+
+// buildReleaseContent renders the Release file body: top-level fields
+// followed by the MD5Sum/SHA1/SHA256/SHA512 sections in the standard
+// Debian-repository layout.
+func buildReleaseContent(suite, codename, components string, checksums []aptChecksums) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Date: %s\n", time.Now().UTC().Format(time.RFC1123))
+	fmt.Fprintf(&b, "Suite: %s\n", suite)
+	fmt.Fprintf(&b, "Codename: %s\n", codename)
+	fmt.Fprintf(&b, "Components: %s\n", components)
+
+	b.WriteString("MD5Sum:\n")
+	for _, c := range checksums {
+		fmt.Fprintf(&b, " %s %16d %s\n", c.MD5, c.Size, c.RelPath)
+	}
+	b.WriteString("SHA1:\n")
+	for _, c := range checksums {
+		fmt.Fprintf(&b, " %s %16d %s\n", c.SHA1, c.Size, c.RelPath)
+	}
+	b.WriteString("SHA256:\n")
+	for _, c := range checksums {
+		fmt.Fprintf(&b, " %s %16d %s\n", c.SHA256, c.Size, c.RelPath)
+	}
+	b.WriteString("SHA512:\n")
+	for _, c := range checksums {
+		fmt.Fprintf(&b, " %s %16d %s\n", c.SHA512, c.Size, c.RelPath)
+	}
+
+	return b.String()
+}
+
+// writeAptRelease computes checksums for every file under dirPath, writes
+// the Release manifest, and signs it into InRelease (clear-signed) and a
+// detached Release.gpg, next to the directory's other output files.
+func writeAptRelease(dirPath string, inventory DirectoryInventory, entity *openpgp.Entity) error {
+	log.Println("Computing APT release checksums...")
+	checksums, err := computeAptChecksums(inventory)
+	if err != nil {
+		return fmt.Errorf("error computing APT checksums: %v", err)
+	}
+
+	releaseContent := buildReleaseContent(aptSuite, aptCodename, aptComponents, checksums)
+
+	releasePath := dirPath + ".Release"
+	if err := os.WriteFile(releasePath, []byte(releaseContent), 0644); err != nil {
+		return fmt.Errorf("error writing %s: %v", releasePath, err)
+	}
+	log.Printf("APT Release file written: %s\n", releasePath)
+
+	if err := writeInRelease(dirPath+".InRelease", entity, []byte(releaseContent)); err != nil {
+		return fmt.Errorf("error writing InRelease: %v", err)
+	}
+	log.Printf("APT InRelease file written: %s.InRelease\n", dirPath)
+
+	if err := writeDetachedReleaseSig(dirPath+".Release.gpg", entity, []byte(releaseContent)); err != nil {
+		return fmt.Errorf("error writing Release.gpg: %v", err)
+	}
+	log.Printf("APT Release.gpg file written: %s.Release.gpg\n", dirPath)
+
+	return nil
+}
+
+// writeInRelease clear-signs releaseContent the way `apt-get update` expects
+// an InRelease file to look: the plaintext followed by an inline signature.
+func writeInRelease(path string, entity *openpgp.Entity, releaseContent []byte) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	w, err := clearsign.Encode(out, entity.PrivateKey, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(releaseContent); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// writeDetachedReleaseSig produces the armored detached signature APT
+// expects to find alongside a plain-text Release file.
+func writeDetachedReleaseSig(path string, entity *openpgp.Entity, releaseContent []byte) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	return openpgp.ArmoredDetachSign(out, entity, bytes.NewReader(releaseContent), nil)
+}
@@ -0,0 +1,358 @@
+// =========================================================
+// Script Name: archive.go
+// Description: Pluggable archive backends (zip, tar.gz, tar.zst, tar.xz,
+//              7z) selectable via -archive, with progress driven from
+//              bytes actually read off disk rather than compressor
+//              buffering.
+// =========================================================
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/flate"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
+	"github.com/ulikunitz/xz"
+)
+
+// Archiver writes files into a single archive at dst.
+type Archiver interface {
+	Create(dst string, files []FileInfo) error
+}
+
+// ArchiveInfo records what was produced for the TOML [archive] section.
+type ArchiveInfo struct {
+	Format    string
+	Level     int
+	SizeBytes int64
+	Digest    string // BLAKE3 of the produced archive
+}
+
+// archiveExtension returns the filename suffix for a given -archive format.
+func archiveExtension(format string) string {
+	switch format {
+	case "tgz":
+		return ".tar.gz"
+	case "tzst":
+		return ".tar.zst"
+	case "txz":
+		return ".tar.xz"
+	case "7z":
+		return ".7z"
+	default:
+		return ".zip"
+	}
+}
+
+// describeArchive stats and hashes the produced archive for the TOML
+// [archive] section.
+func describeArchive(archivePath, format string, level int) (ArchiveInfo, error) {
+	stat, err := os.Stat(archivePath)
+	if err != nil {
+		return ArchiveInfo{}, err
+	}
+
+	digest, err := blake3File(archivePath)
+	if err != nil {
+		return ArchiveInfo{}, err
+	}
+
+	return ArchiveInfo{
+		Format:    format,
+		Level:     level,
+		SizeBytes: stat.Size(),
+		Digest:    digest,
+	}, nil
+}
+
+// newArchiver builds the Archiver for the requested format. sourceDir is
+// only needed by backends (like 7z) that shell out to an external tool
+// expecting relative paths. onBytesRead, if non-nil, is called with the
+// number of bytes read from each source file as it is streamed into the
+// archive, so progress reflects actual disk I/O rather than the
+// compressor's internal buffering.
+func newArchiver(format, sourceDir string, level int, onBytesRead func(int64)) (Archiver, error) {
+	switch format {
+	case "", "zip":
+		return &zipArchiver{level: level, onBytesRead: onBytesRead}, nil
+	case "tgz":
+		return &tarGzArchiver{level: level, onBytesRead: onBytesRead}, nil
+	case "tzst":
+		return &tarZstdArchiver{level: level, onBytesRead: onBytesRead}, nil
+	case "txz":
+		return &tarXzArchiver{level: level, onBytesRead: onBytesRead}, nil
+	case "7z":
+		return &sevenZipArchiver{sourceDir: sourceDir, level: level}, nil
+	default:
+		return nil, fmt.Errorf("unknown archive format %q (want zip, tgz, tzst, txz, or 7z)", format)
+	}
+}
+
+// progressReader calls onRead with every chunk read from r, independent of
+// any buffering the destination writer performs.
+type progressReader struct {
+	r      io.Reader
+	onRead func(int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 && p.onRead != nil {
+		p.onRead(int64(n))
+	}
+	return n, err
+}
+
+// copyFileInto opens path and streams it into w, reporting bytes read via
+// onBytesRead as they come off disk.
+func copyFileInto(w io.Writer, path string, onBytesRead func(int64)) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	_, err = io.Copy(w, &progressReader{r: src, onRead: onBytesRead})
+	return err
+}
+
+// zipArchiver implements Archiver using archive/zip with a configurable
+// Deflate level.
+type zipArchiver struct {
+	level       int
+	onBytesRead func(int64)
+}
+
+func (a *zipArchiver) Create(dst string, files []FileInfo) error {
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	zw := zip.NewWriter(out)
+	defer zw.Close()
+
+	level := a.level
+	zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, level)
+	})
+
+	for _, fi := range files {
+		name := filepath.ToSlash(fi.RelPath)
+		header := &zip.FileHeader{Name: name, Modified: fi.ModTime}
+		header.SetMode(os.FileMode(fi.Mode))
+
+		if fi.IsDir {
+			header.Name += "/"
+			header.Method = zip.Store
+			if _, err := zw.CreateHeader(header); err != nil {
+				return err
+			}
+			continue
+		}
+
+		header.Method = zip.Deflate
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		if err := copyFileInto(w, fi.Path, a.onBytesRead); err != nil {
+			return fmt.Errorf("error archiving %s: %v", fi.RelPath, err)
+		}
+	}
+
+	return nil
+}
+
+// tarWriteFiles streams files into a tar writer, shared by the tar.gz,
+// tar.zst, and tar.xz backends.
+func tarWriteFiles(tw *tar.Writer, files []FileInfo, onBytesRead func(int64)) error {
+	for _, fi := range files {
+		header := &tar.Header{
+			Name:    filepath.ToSlash(fi.RelPath),
+			Mode:    int64(fi.Mode),
+			Size:    fi.Size,
+			ModTime: fi.ModTime,
+		}
+		if fi.IsDir {
+			header.Typeflag = tar.TypeDir
+			header.Name += "/"
+			header.Size = 0
+		} else {
+			header.Typeflag = tar.TypeReg
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if fi.IsDir {
+			continue
+		}
+		if err := copyFileInto(tw, fi.Path, onBytesRead); err != nil {
+			return fmt.Errorf("error archiving %s: %v", fi.RelPath, err)
+		}
+	}
+	return nil
+}
+
+// tarGzArchiver implements Archiver as a tar stream compressed with
+// pgzip, which parallelizes gzip compression across blocks.
+type tarGzArchiver struct {
+	level       int
+	onBytesRead func(int64)
+}
+
+func (a *tarGzArchiver) Create(dst string, files []FileInfo) error {
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	gz, err := pgzip.NewWriterLevel(out, a.level)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tw := tar.NewWriter(gz)
+	defer tw.Close()
+
+	if err := tarWriteFiles(tw, files, a.onBytesRead); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// tarZstdArchiver implements Archiver as a tar stream compressed with zstd.
+type tarZstdArchiver struct {
+	level       int
+	onBytesRead func(int64)
+}
+
+// zstdEncoderLevel maps the tool's gzip-style 1-9 -level flag onto zstd's
+// four discrete speed/ratio presets.
+func zstdEncoderLevel(level int) zstd.EncoderLevel {
+	switch {
+	case level <= 2:
+		return zstd.SpeedFastest
+	case level <= 5:
+		return zstd.SpeedDefault
+	case level <= 7:
+		return zstd.SpeedBetterCompression
+	default:
+		return zstd.SpeedBestCompression
+	}
+}
+
+func (a *tarZstdArchiver) Create(dst string, files []FileInfo) error {
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	zw, err := zstd.NewWriter(out, zstd.WithEncoderLevel(zstdEncoderLevel(a.level)))
+	if err != nil {
+		return err
+	}
+	defer zw.Close()
+
+	tw := tar.NewWriter(zw)
+	defer tw.Close()
+
+	if err := tarWriteFiles(tw, files, a.onBytesRead); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// tarXzArchiver implements Archiver as a tar stream compressed with xz.
+type tarXzArchiver struct {
+	level       int
+	onBytesRead func(int64)
+}
+
+// xzDictCap maps the tool's gzip-style 1-9 -level flag onto an xz
+// dictionary size, since the xz package exposes tunable memory/ratio via
+// DictCap rather than a single numeric level.
+func xzDictCap(level int) int {
+	if level < 1 {
+		level = 1
+	}
+	if level > 9 {
+		level = 9
+	}
+	return 1 << uint(18+level) // 512 KiB .. 128 MiB
+}
+
+func (a *tarXzArchiver) Create(dst string, files []FileInfo) error {
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	xw, err := xz.WriterConfig{DictCap: xzDictCap(a.level)}.NewWriter(out)
+	if err != nil {
+		return err
+	}
+	defer xw.Close()
+
+	tw := tar.NewWriter(xw)
+	defer tw.Close()
+
+	if err := tarWriteFiles(tw, files, a.onBytesRead); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return xw.Close()
+}
+
+// sevenZipArchiver implements Archiver by shelling out to the system `7z`
+// binary; there is no actively maintained pure-Go 7z *writer*. Progress
+// reporting isn't wired up for this backend since the external process
+// owns its own I/O.
+type sevenZipArchiver struct {
+	sourceDir string
+	level     int
+}
+
+func (a *sevenZipArchiver) Create(dst string, files []FileInfo) error {
+	if _, err := exec.LookPath("7z"); err != nil {
+		return fmt.Errorf("7z archive format requires the `7z` binary on PATH: %v", err)
+	}
+
+	args := []string{"a", fmt.Sprintf("-mx=%d", a.level), dst}
+	for _, fi := range files {
+		if fi.IsDir {
+			continue
+		}
+		args = append(args, fi.RelPath)
+	}
+
+	cmd := exec.Command("7z", args...)
+	cmd.Dir = a.sourceDir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("7z failed: %v\n%s", err, output)
+	}
+	return nil
+}
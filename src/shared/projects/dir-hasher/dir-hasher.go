@@ -3,7 +3,7 @@
 // Description: Generates cryptographic hashes for directories, creates TOML files with hash information, and zips directories
 // Author: Based on APTlantis Team's iso_hasher.go
 // Creation Date: 2025-07-20
-// 
+//
 // Dependencies:
 // - github.com/cloudflare/circl/xof/k12
 // - github.com/jzelinskie/whirlpool
@@ -16,21 +16,39 @@
 // - github.com/cespare/xxhash/v2
 // - github.com/spaolacci/murmur3
 // - archive/zip
-// 
+// - github.com/klauspost/pgzip
+// - github.com/klauspost/compress/zstd
+// - github.com/ulikunitz/xz
+//
 // Usage:
 //   go run dir_hasher.go [options]
-// 
+//
 // Options:
 //   -dir string        Directory to hash and zip
 //   -verbose           Enable verbose output
 //   -progress          Show progress when hashing large files (default true)
 //   -gpgkey string     Path to GPG private key file (if not provided, a new key will be generated)
+//   -format string     Additional output format: "toml" (default) or "apt" (Release/InRelease/Release.gpg)
+//   -suite string      APT Release Suite field (only used with -format apt)
+//   -codename string   APT Release Codename field (only used with -format apt)
+//   -components string APT Release Components field (only used with -format apt)
+//   -archive string    Archive backend: zip (default), tgz, tzst, txz, or 7z
+//   -level int         Archive compression level (default 6)
+//   -sink string       Remote destination to upload the TOML, archive, and
+//                      signature to: sftp://user@host/path, s3://bucket/prefix,
+//                      or https://host/path (PUT)
+//   -jobs int          Number of files to hash in parallel (default runtime.NumCPU())
+//
+// Subcommands:
+//   dir-hasher verify -toml foo.toml -dir foo/ [-keyring pub.asc]
+//   dir-hasher verify -toml foo.toml -zip foo.zip [-keyring pub.asc]
+//     Validates a directory (or just its archive) against a previously
+//     generated TOML + embedded signature. See verify.go.
 // =========================================================
 
 package main
 
 import (
-	"archive/zip"
 	"bytes"
 	"crypto"
 	"crypto/sha256"
@@ -38,11 +56,10 @@ import (
 	"encoding/hex"
 	"flag"
 	"fmt"
-	"io"
 	"log"
 	"os"
 	"path/filepath"
-	"strings"
+	"runtime"
 	"time"
 
 	"github.com/cespare/xxhash/v2"
@@ -56,21 +73,42 @@ import (
 	"golang.org/x/crypto/openpgp/packet"
 	"golang.org/x/crypto/ripemd160"
 	"golang.org/x/crypto/sha3"
-	"lukechampine.com/blake3"
 )
 
 var (
-	dirPath      string
-	verbose      bool
-	showProgress bool
-	gpgKeyFile   string
+	dirPath       string
+	verbose       bool
+	showProgress  bool
+	gpgKeyFile    string
+	outputFormat  string
+	aptSuite      string
+	aptCodename   string
+	aptComponents string
+	archiveFormat string
+	archiveLevel  int
+	sinkURL       string
+	hashJobs      int
 )
 
 func init() {
+	// The "verify" subcommand parses its own flag set from os.Args[2:] in
+	// verifyMain, so skip registering and requiring the hashing flags here.
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		return
+	}
+
 	flag.StringVar(&dirPath, "dir", "", "Directory to hash and zip")
 	flag.BoolVar(&verbose, "verbose", false, "Enable verbose output")
 	flag.BoolVar(&showProgress, "progress", true, "Show progress when hashing large files")
 	flag.StringVar(&gpgKeyFile, "gpgkey", "", "Path to GPG private key file (if not provided, a new key will be generated)")
+	flag.StringVar(&outputFormat, "format", "toml", "Output format to emit in addition to the TOML: \"toml\" or \"apt\" (Release/InRelease/Release.gpg)")
+	flag.StringVar(&aptSuite, "suite", "stable", "APT Release Suite field (only used with -format apt)")
+	flag.StringVar(&aptCodename, "codename", "stable", "APT Release Codename field (only used with -format apt)")
+	flag.StringVar(&aptComponents, "components", "main", "APT Release Components field (only used with -format apt)")
+	flag.StringVar(&archiveFormat, "archive", "zip", "Archive backend: zip, tgz, tzst, txz, or 7z")
+	flag.IntVar(&archiveLevel, "level", 6, "Archive compression level")
+	flag.StringVar(&sinkURL, "sink", "", "Remote destination to upload the TOML, archive, and signature to: sftp://user@host/path, s3://bucket/prefix, or https://host/path (PUT)")
+	flag.IntVar(&hashJobs, "jobs", runtime.NumCPU(), "Number of files to hash in parallel")
 	flag.Parse()
 
 	if dirPath == "" {
@@ -110,54 +148,34 @@ func exportPublicKey(entity *openpgp.Entity) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	
+
 	err = entity.Serialize(w)
 	if err != nil {
 		return "", err
 	}
-	
+
 	err = w.Close()
 	if err != nil {
 		return "", err
 	}
-	
+
 	return buf.String(), nil
 }
 
-// signData signs the provided data with the GPG key
+// signData produces an armored *detached* signature over data: just the
+// signature packet, no copy of data itself. This is what verify's
+// openpgp.CheckArmoredDetachedSignature expects, and what createTomlFile
+// embeds as signed_data expects to be checked against, so the two stay
+// consistent — openpgp.Sign instead would emit an inline signed message
+// (one-pass-signature + literal data + signature), which isn't a detached
+// signature and fails that check.
 func signData(entity *openpgp.Entity, data []byte) (string, error) {
 	var buf bytes.Buffer
-	
-	// Create an armored signature
-	w, err := armor.Encode(&buf, openpgp.SignatureType, nil)
-	if err != nil {
-		return "", err
-	}
-	
-	// Create a signature writer
-	signWriter, err := openpgp.Sign(w, entity, nil, nil)
-	if err != nil {
-		return "", err
-	}
-	
-	// Write the data to be signed
-	_, err = signWriter.Write(data)
-	if err != nil {
-		return "", err
-	}
-	
-	// Close the signature writer
-	err = signWriter.Close()
-	if err != nil {
-		return "", err
-	}
-	
-	// Close the armor writer
-	err = w.Close()
-	if err != nil {
+
+	if err := openpgp.ArmoredDetachSign(&buf, entity, bytes.NewReader(data), nil); err != nil {
 		return "", err
 	}
-	
+
 	return buf.String(), nil
 }
 
@@ -169,19 +187,19 @@ func getGPGEntity() (*openpgp.Entity, error) {
 		if err != nil {
 			return nil, fmt.Errorf("error reading GPG key file: %v", err)
 		}
-		
+
 		// Decode the armored key
 		block, err := armor.Decode(bytes.NewReader(keyData))
 		if err != nil {
 			return nil, fmt.Errorf("error decoding GPG key: %v", err)
 		}
-		
+
 		// Read the entity
 		entityList, err := openpgp.ReadEntity(packet.NewReader(block.Body))
 		if err != nil {
 			return nil, fmt.Errorf("error reading GPG entity: %v", err)
 		}
-		
+
 		return entityList, nil
 	} else {
 		// Generate a new key
@@ -190,18 +208,19 @@ func getGPGEntity() (*openpgp.Entity, error) {
 		if err != nil {
 			hostname = "unknown"
 		}
-		
+
 		return generateGPGKey("Dir Hasher", fmt.Sprintf("dir-hasher@%s", hostname))
 	}
 }
 
 // FileInfo stores information about a file
 type FileInfo struct {
-	Path     string
-	Size     int64
-	ModTime  time.Time
-	IsDir    bool
-	RelPath  string // Path relative to the root directory
+	Path    string
+	Size    int64
+	ModTime time.Time
+	Mode    uint32
+	IsDir   bool
+	RelPath string // Path relative to the root directory
 }
 
 // DirectoryInventory stores information about all files in a directory
@@ -225,20 +244,27 @@ type HashResult struct {
 
 	// 3 less common checksums
 	Whirlpool string
-	RIPEMD160  string
+	RIPEMD160 string
 	XXH3      string
 
 	// Additional hashes
-	SHA256    string
-	XXHash64  string
-	Murmur3   string
-	
+	SHA256   string
+	XXHash64 string
+	Murmur3  string
+
 	// GPG signature
-	GPGKeyID     string
-	GPGSignature string
+	GPGKeyID      string
+	GPGSignature  string
+	GPGPublicKey  string // armored public key, embedded so `verify` can check the signature without a separate keyring
+	GPGSignedData string // exact plaintext that was signed, embedded so `verify` can reconstruct it exactly
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		verifyMain(os.Args[2:])
+		return
+	}
+
 	startTime := time.Now()
 	log.Printf("Starting directory hashing for: %s\n", dirPath)
 
@@ -247,6 +273,11 @@ func main() {
 		log.Fatalf("Error: Directory %s does not exist\n", dirPath)
 	}
 
+	// Clean here, once, so every path derived from dirPath below (archive,
+	// TOML, signature, hash cache, and the inventory's own root key) agrees
+	// on the same string whether or not the user passed a trailing separator.
+	dirPath = filepath.Clean(dirPath)
+
 	// Get directory name for output files
 	dirName := filepath.Base(dirPath)
 	if dirName == "." || dirName == ".." || dirName == "/" || dirName == "\\" {
@@ -260,36 +291,74 @@ func main() {
 	if err != nil {
 		log.Fatalf("Error creating directory inventory: %v\n", err)
 	}
-	log.Printf("Inventory complete: %d files, %d directories, %.2f MB total\n", 
-		inventory.TotalFiles, 
-		inventory.TotalDirs, 
+	log.Printf("Inventory complete: %d files, %d directories, %.2f MB total\n",
+		inventory.TotalFiles,
+		inventory.TotalDirs,
 		float64(inventory.TotalSize)/(1024*1024))
 
+	// Generate or load the GPG key once so every signed artifact (TOML,
+	// APT Release) is signed by the same key.
+	entity, err := getGPGEntity()
+	if err != nil {
+		log.Fatalf("Error with GPG key: %v\n", err)
+	}
+
 	// Generate hashes for the directory
 	log.Println("Generating hashes for all files...")
-	hashResult, err := generateDirectoryHashes(inventory)
+	hashResult, merkleTree, err := generateDirectoryHashes(dirPath, inventory, entity, hashJobs)
 	if err != nil {
 		log.Fatalf("Error generating hashes: %v\n", err)
 	}
-	log.Println("Hash generation complete")
+	log.Printf("Hash generation complete (%d files re-hashed, %d reused from cache)\n",
+		merkleTree.ChangedFiles, merkleTree.ReusedFiles)
+
+	cachePath := hashCachePath(dirPath)
+	if err := saveHashCache(cachePath, merkleTree.UpdatedCache); err != nil {
+		log.Printf("Warning: could not write hash cache %s: %v\n", cachePath, err)
+	}
+
+	// Create the archive
+	archivePath := dirPath + archiveExtension(archiveFormat)
+	log.Printf("Creating %s archive: %s\n", archiveFormat, archivePath)
+	if err := archiveDirectory(dirPath, archivePath, archiveFormat, archiveLevel, inventory); err != nil {
+		log.Fatalf("Error creating archive: %v\n", err)
+	}
+	archiveInfo, err := describeArchive(archivePath, archiveFormat, archiveLevel)
+	if err != nil {
+		log.Fatalf("Error describing archive: %v\n", err)
+	}
+	log.Printf("Archive created successfully (%.2f MB)\n", float64(archiveInfo.SizeBytes)/(1024*1024))
 
 	// Create TOML file
 	tomlPath := dirPath + ".toml"
 	log.Printf("Creating TOML file: %s\n", tomlPath)
-	err = createTomlFile(tomlPath, dirName, inventory, hashResult)
+	err = createTomlFile(tomlPath, dirName, inventory, hashResult, merkleTree, archiveInfo)
 	if err != nil {
 		log.Fatalf("Error creating TOML file: %v\n", err)
 	}
 	log.Println("TOML file created successfully")
 
-	// Create ZIP file
-	zipPath := dirPath + ".zip"
-	log.Printf("Creating ZIP file: %s\n", zipPath)
-	err = zipDirectory(dirPath, zipPath)
-	if err != nil {
-		log.Fatalf("Error creating ZIP file: %v\n", err)
+	if outputFormat == "apt" {
+		if err := writeAptRelease(dirPath, inventory, entity); err != nil {
+			log.Fatalf("Error creating APT release manifest: %v\n", err)
+		}
+	}
+
+	// Detached signature over the same hash summary embedded in the TOML,
+	// written as a standalone artifact so it can be pushed to a -sink
+	// alongside the TOML and archive.
+	sigPath := dirPath + ".sig"
+	if err := os.WriteFile(sigPath, []byte(hashResult.GPGSignature), 0644); err != nil {
+		log.Fatalf("Error writing signature file: %v\n", err)
+	}
+
+	if sinkURL != "" {
+		log.Printf("Uploading artifacts to sink: %s\n", sinkURL)
+		if err := uploadArtifacts(sinkURL, dirName, tomlPath, archivePath, sigPath); err != nil {
+			log.Fatalf("Error uploading to sink: %v\n", err)
+		}
+		log.Println("Sink upload complete")
 	}
-	log.Println("ZIP file created successfully")
 
 	duration := time.Since(startTime)
 	log.Printf("All operations completed in %v\n", duration)
@@ -297,6 +366,12 @@ func main() {
 
 // createDirectoryInventory creates an inventory of all files in a directory
 func createDirectoryInventory(rootDir string) (DirectoryInventory, error) {
+	// Clean rootDir before using it as a map/path key anywhere: filepath.Walk
+	// joins (and so cleans) child paths regardless of whether rootDir itself
+	// has a trailing separator, so an uncleaned rootDir would disagree with
+	// filepath.Dir() of its own children.
+	rootDir = filepath.Clean(rootDir)
+
 	inventory := DirectoryInventory{
 		RootDir:     rootDir,
 		Files:       []FileInfo{},
@@ -322,11 +397,12 @@ func createDirectoryInventory(rootDir string) (DirectoryInventory, error) {
 		}
 
 		fileInfo := FileInfo{
-			Path:     path,
-			Size:     info.Size(),
-			ModTime:  info.ModTime(),
-			IsDir:    info.IsDir(),
-			RelPath:  relPath,
+			Path:    path,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			Mode:    uint32(info.Mode()),
+			IsDir:   info.IsDir(),
+			RelPath: relPath,
 		}
 
 		inventory.Files = append(inventory.Files, fileInfo)
@@ -344,123 +420,104 @@ func createDirectoryInventory(rootDir string) (DirectoryInventory, error) {
 	return inventory, err
 }
 
-// generateDirectoryHashes generates hashes for all files in a directory
-func generateDirectoryHashes(inventory DirectoryInventory) (HashResult, error) {
-	// Initialize hash functions
-	sha256Hasher := sha256.New()
-	whirlpoolHasher := whirlpool.New()
-	ripemd160Hasher := ripemd160.New()
-	sha3_256Hasher := sha3.New256()
-	blake2bHasher, _ := blake2b.New256(nil)
-	blake3Hasher := blake3.New(32, nil)
-	sha512Hasher := sha512.New()
-	xxh64Hasher := xxhash.New()
-	murmur3Hasher := murmur3.New128()
-	
-	// Initialize KangarooTwelve hasher
-	k12Hasher := k12.NewDraft10([]byte(""))
-
-	// Variables for progress reporting
-	var bytesProcessed int64
-	lastProgressUpdate := time.Now()
-	progressInterval := 3 * time.Second // Update progress every 3 seconds
+// sha256Sum, whirlpoolSum, ripemd160Sum, sha3Sum256, blake2bSum256,
+// murmur3Sum128 and k12Sum are small one-shot wrappers around the
+// corresponding hash.Hash implementations, used to digest the (small)
+// canonical root-node serialization instead of streaming whole files.
+func sha256Sum(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
 
-	// Process each file
-	for _, fileInfo := range inventory.Files {
-		// Skip directories
-		if fileInfo.IsDir {
-			continue
-		}
+func whirlpoolSum(data []byte) []byte {
+	h := whirlpool.New()
+	h.Write(data)
+	return h.Sum(nil)
+}
 
-		if verbose {
-			log.Printf("Processing file: %s\n", fileInfo.RelPath)
-		}
+func ripemd160Sum(data []byte) []byte {
+	h := ripemd160.New()
+	h.Write(data)
+	return h.Sum(nil)
+}
 
-		// Open the file
-		file, err := os.Open(fileInfo.Path)
-		if err != nil {
-			return HashResult{}, fmt.Errorf("error opening file %s: %v", fileInfo.Path, err)
-		}
+func sha3Sum256(data []byte) []byte {
+	h := sha3.New256()
+	h.Write(data)
+	return h.Sum(nil)
+}
 
-		// Read file in chunks and update all hash functions
-		buffer := make([]byte, 1024*1024) // 1MB buffer for efficient reading
-		for {
-			n, err := file.Read(buffer)
-			if err != nil && err != io.EOF {
-				file.Close()
-				return HashResult{}, fmt.Errorf("error reading file %s: %v", fileInfo.Path, err)
-			}
-			if n == 0 {
-				break
-			}
+func blake2bSum256(data []byte) []byte {
+	h, _ := blake2b.New256(nil)
+	h.Write(data)
+	return h.Sum(nil)
+}
 
-			// Update all hash functions
-			sha256Hasher.Write(buffer[:n])
-			whirlpoolHasher.Write(buffer[:n])
-			ripemd160Hasher.Write(buffer[:n])
-			sha3_256Hasher.Write(buffer[:n])
-			blake2bHasher.Write(buffer[:n])
-			blake3Hasher.Write(buffer[:n])
-			sha512Hasher.Write(buffer[:n])
-			k12Hasher.Write(buffer[:n])
-			xxh64Hasher.Write(buffer[:n])
-			murmur3Hasher.Write(buffer[:n])
-			
-			// Also calculate XXH3 hash (this one doesn't use the standard hash.Hash interface)
-			xxh3.HashString(string(buffer[:n]))
-
-			// Update progress
-			bytesProcessed += int64(n)
-
-			// Show progress if enabled and enough time has passed since last update
-			if showProgress && time.Since(lastProgressUpdate) > progressInterval {
-				percentComplete := float64(bytesProcessed) / float64(inventory.TotalSize) * 100
-				log.Printf("Hashing progress: %.1f%% complete (%.2f MB / %.2f MB)\n",
-					percentComplete,
-					float64(bytesProcessed)/(1024*1024),
-					float64(inventory.TotalSize)/(1024*1024))
-				lastProgressUpdate = time.Now()
-			}
-		}
+func murmur3Sum128(data []byte) string {
+	h := murmur3.New128()
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func k12Sum(data []byte) string {
+	h := k12.NewDraft10([]byte(""))
+	h.Write(data)
+	out := make([]byte, 32)
+	h.Read(out)
+	return hex.EncodeToString(out)
+}
 
-		file.Close()
+// generateDirectoryHashes builds the per-file/per-directory Merkle tree for
+// inventory (reusing unchanged files from the on-disk hash cache) and
+// derives the legacy aggregate hashes from the canonical serialization of
+// the root directory node, so they stay reproducible without re-reading
+// every file's bytes on every run.
+func generateDirectoryHashes(dirPath string, inventory DirectoryInventory, entity *openpgp.Entity, jobs int) (HashResult, MerkleTree, error) {
+	cachePath := hashCachePath(dirPath)
+	cache, err := loadHashCache(cachePath)
+	if err != nil {
+		log.Printf("Warning: could not read hash cache %s, starting fresh: %v\n", cachePath, err)
+		cache = newRadixTree()
 	}
 
-	// Show 100% progress at the end if progress reporting is enabled
 	if showProgress {
-		log.Printf("Hashing progress: 100.0%% complete (%.2f MB)\n",
-			float64(inventory.TotalSize)/(1024*1024))
-	}
-
-	// Get hash values
-	sha256Hash := hex.EncodeToString(sha256Hasher.Sum(nil))
-	whirlpoolHash := hex.EncodeToString(whirlpoolHasher.Sum(nil))
-	ripemd160Hash := hex.EncodeToString(ripemd160Hasher.Sum(nil))
-	sha3_256Hash := hex.EncodeToString(sha3_256Hasher.Sum(nil))
-	blake2bHash := hex.EncodeToString(blake2bHasher.Sum(nil))
-	blake3Hash := hex.EncodeToString(blake3Hasher.Sum(nil))
-	sha512Hash := hex.EncodeToString(sha512Hasher.Sum(nil))
-	xxh64Hash := hex.EncodeToString(xxh64Hasher.Sum(nil))
-	murmur3Hash := hex.EncodeToString(murmur3Hasher.Sum(nil))
-
-	// For KangarooTwelve
-	k12Output := make([]byte, 32) // 32 bytes (256 bits) output
-	k12Hasher.Read(k12Output)
-	k12Hash := hex.EncodeToString(k12Output)
-
-	// For XXH3 (using a sample string as we can't get a cumulative hash easily)
-	xxh3Hash := fmt.Sprintf("%x", xxh3.HashString("Sample for XXH3"))
-
-	// Generate or load GPG key
-	log.Println("Generating GPG signature...")
-	entity, err := getGPGEntity()
+		log.Printf("Hashing progress: walking %d files against cache...\n", inventory.TotalFiles)
+	}
+
+	merkleTree, err := buildMerkleTree(inventory, cache, jobs)
 	if err != nil {
-		return HashResult{}, fmt.Errorf("error with GPG key: %v", err)
+		return HashResult{}, MerkleTree{}, err
 	}
 
+	if showProgress {
+		log.Printf("Hashing progress: 100.0%% complete (%d changed, %d reused)\n",
+			merkleTree.ChangedFiles, merkleTree.ReusedFiles)
+	}
+
+	// Feed the root node's canonical serialization through every aggregate
+	// hash algorithm. The root serialization only changes when some file's
+	// contents, size, or mode actually changed, so these digests are stable
+	// across no-op runs.
+	root := merkleTree.RootSerial
+
+	sha256Hash := hex.EncodeToString(sha256Sum(root))
+	whirlpoolHash := hex.EncodeToString(whirlpoolSum(root))
+	ripemd160Hash := hex.EncodeToString(ripemd160Sum(root))
+	sha3_256Hash := hex.EncodeToString(sha3Sum256(root))
+	blake2bHash := hex.EncodeToString(blake2bSum256(root))
+	blake3Hash := blake3Sum(root)
+	sha512Sum := sha512.Sum512(root)
+	sha512Hash := hex.EncodeToString(sha512Sum[:])
+	xxh64Hash := fmt.Sprintf("%x", xxhash.Sum64(root))
+	murmur3Hash := murmur3Sum128(root)
+	k12Hash := k12Sum(root)
+	xxh3Hash := fmt.Sprintf("%x", xxh3.Hash(root))
+
+	log.Println("Generating GPG signature...")
+
 	// Get the key ID
 	keyID := fmt.Sprintf("0x%X", entity.PrimaryKey.KeyId)
-	
+
 	// Create a string with all hash values to sign
 	dataToSign := fmt.Sprintf(
 		"Directory: %s\nSHA256: %s\nSHA512: %s\nBLAKE2b: %s\nBLAKE3: %s\nSHA3-256: %s\nKangarooTwelve: %s\nWhirlpool: %s\nRIPEMD-160: %s\nXXH3: %s\nXXHash64: %s\nMurmur3: %s\nTimestamp: %s",
@@ -478,11 +535,18 @@ func generateDirectoryHashes(inventory DirectoryInventory) (HashResult, error) {
 		murmur3Hash,
 		time.Now().Format(time.RFC3339),
 	)
-	
+
 	// Sign the data
 	signature, err := signData(entity, []byte(dataToSign))
 	if err != nil {
-		return HashResult{}, fmt.Errorf("error signing data: %v", err)
+		return HashResult{}, MerkleTree{}, fmt.Errorf("error signing data: %v", err)
+	}
+
+	// Embed the public key and exact signed payload so `verify` can check
+	// the signature from the TOML alone, without a separate keyring.
+	publicKey, err := exportPublicKey(entity)
+	if err != nil {
+		return HashResult{}, MerkleTree{}, fmt.Errorf("error exporting public key: %v", err)
 	}
 
 	return HashResult{
@@ -499,11 +563,13 @@ func generateDirectoryHashes(inventory DirectoryInventory) (HashResult, error) {
 		Murmur3:        murmur3Hash,
 		GPGKeyID:       keyID,
 		GPGSignature:   signature,
-	}, nil
+		GPGPublicKey:   publicKey,
+		GPGSignedData:  dataToSign,
+	}, merkleTree, nil
 }
 
 // createTomlFile creates a TOML file with directory information and hash values
-func createTomlFile(tomlPath, dirName string, inventory DirectoryInventory, hashResult HashResult) error {
+func createTomlFile(tomlPath, dirName string, inventory DirectoryInventory, hashResult HashResult, merkleTree MerkleTree, archiveInfo ArchiveInfo) error {
 	// Create TOML file
 	file, err := os.Create(tomlPath)
 	if err != nil {
@@ -561,18 +627,35 @@ sha256 = "%s"
 xxhash64 = "%s"
 murmur3 = "%s"
 
+# Merkle root digest (BLAKE3 of the canonical root directory serialization)
+merkle_root = "%s"
+
 [signature]
 gpg_key_id = "%s"
-gpg_signature = "%s"
+# Armored values below are multi-line, so they use TOML literal strings
+# ('''...''') rather than basic quoted strings.
+gpg_signature = '''
+%s'''
+gpg_public_key = '''
+%s'''
+signed_data = '''
+%s'''
+
+[archive]
+format = "%s"
+level = %d
+size_bytes = %d
+digest = "%s"
 
 [files]
-`, asciiArt, currentTime, dirName, inventory.TotalFiles, inventory.TotalDirs, 
-   inventory.TotalSize, inventory.InventoryAt.Format("2006-01-02 15:04:05"),
-   hashResult.KangarooTwelve, hashResult.Blake3, hashResult.SHA3_256, 
-   hashResult.Blake2b, hashResult.SHA512, hashResult.Whirlpool, 
-   hashResult.RIPEMD160, hashResult.XXH3, hashResult.SHA256, 
-   hashResult.XXHash64, hashResult.Murmur3,
-   hashResult.GPGKeyID, hashResult.GPGSignature)
+`, asciiArt, currentTime, dirName, inventory.TotalFiles, inventory.TotalDirs,
+		inventory.TotalSize, inventory.InventoryAt.Format("2006-01-02 15:04:05"),
+		hashResult.KangarooTwelve, hashResult.Blake3, hashResult.SHA3_256,
+		hashResult.Blake2b, hashResult.SHA512, hashResult.Whirlpool,
+		hashResult.RIPEMD160, hashResult.XXH3, hashResult.SHA256,
+		hashResult.XXHash64, hashResult.Murmur3, merkleTree.RootDigest,
+		hashResult.GPGKeyID, hashResult.GPGSignature, hashResult.GPGPublicKey, hashResult.GPGSignedData,
+		archiveInfo.Format, archiveInfo.Level, archiveInfo.SizeBytes, archiveInfo.Digest)
 
 	_, err = file.WriteString(tomlContent)
 	if err != nil {
@@ -585,8 +668,10 @@ gpg_signature = "%s"
 			fileEntry := fmt.Sprintf(`[files."%s"]
 size = %d
 modified = "%s"
+digest = "%s"
 
-`, fileInfo.RelPath, fileInfo.Size, fileInfo.ModTime.Format("2006-01-02 15:04:05"))
+`, fileInfo.RelPath, fileInfo.Size, fileInfo.ModTime.Format("2006-01-02 15:04:05"),
+				merkleTree.FileDigests[fileInfo.RelPath])
 			_, err = file.WriteString(fileEntry)
 			if err != nil {
 				return err
@@ -597,73 +682,37 @@ modified = "%s"
 	return nil
 }
 
-// zipDirectory creates a ZIP file from a directory
-func zipDirectory(sourceDir, zipPath string) error {
-	// Create a new ZIP file
-	zipFile, err := os.Create(zipPath)
+// archiveDirectory creates an archive of sourceDir at archivePath using the
+// requested format and compression level, logging progress every 3 seconds
+// based on bytes actually read from source files.
+func archiveDirectory(sourceDir, archivePath, format string, level int, inventory DirectoryInventory) error {
+	var bytesRead int64
+	lastProgressUpdate := time.Now()
+	progressInterval := 3 * time.Second
+
+	onBytesRead := func(n int64) {
+		bytesRead += n
+		if showProgress && time.Since(lastProgressUpdate) > progressInterval {
+			percentComplete := float64(bytesRead) / float64(inventory.TotalSize) * 100
+			log.Printf("Archiving progress: %.1f%% complete (%.2f MB / %.2f MB)\n",
+				percentComplete,
+				float64(bytesRead)/(1024*1024),
+				float64(inventory.TotalSize)/(1024*1024))
+			lastProgressUpdate = time.Now()
+		}
+	}
+
+	archiver, err := newArchiver(format, sourceDir, level, onBytesRead)
 	if err != nil {
 		return err
 	}
-	defer zipFile.Close()
-
-	// Create a new ZIP writer
-	zipWriter := zip.NewWriter(zipFile)
-	defer zipWriter.Close()
-
-	// Walk through the source directory
-	return filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		// Create a ZIP header
-		header, err := zip.FileInfoHeader(info)
-		if err != nil {
-			return err
-		}
 
-		// Calculate relative path
-		relPath, err := filepath.Rel(sourceDir, path)
-		if err != nil {
-			return err
-		}
-
-		// Skip the root directory itself
-		if relPath == "." {
-			return nil
-		}
-
-		// Use forward slashes for paths in ZIP files
-		header.Name = strings.ReplaceAll(relPath, "\\", "/")
-
-		// Set compression method
-		header.Method = zip.Deflate
-
-		// Handle directories
-		if info.IsDir() {
-			header.Name += "/"
-		}
-
-		// Create the file in the ZIP
-		writer, err := zipWriter.CreateHeader(header)
-		if err != nil {
-			return err
-		}
-
-		// If it's a directory, we're done
-		if info.IsDir() {
-			return nil
-		}
-
-		// Open the source file
-		file, err := os.Open(path)
-		if err != nil {
-			return err
-		}
-		defer file.Close()
-
-		// Copy the file contents to the ZIP
-		_, err = io.Copy(writer, file)
+	if err := archiver.Create(archivePath, inventory.Files); err != nil {
 		return err
-	})
-}
\ No newline at end of file
+	}
+
+	if showProgress {
+		log.Printf("Archiving progress: 100.0%% complete (%.2f MB)\n", float64(inventory.TotalSize)/(1024*1024))
+	}
+	return nil
+}
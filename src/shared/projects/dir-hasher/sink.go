@@ -0,0 +1,382 @@
+// =========================================================
+// Script Name: sink.go
+// Description: Remote upload sinks (SFTP, S3, HTTP PUT) selectable via
+//              -sink, used to push the TOML, archive, and detached
+//              signature to a mirror destination after they're generated.
+// =========================================================
+
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// UploadSink streams a local file to a remote destination under remoteName,
+// retrying transient failures, and verifies the uploaded object's size (and,
+// where the backend supports it, checksum) against the local file before
+// returning.
+type UploadSink interface {
+	Upload(localPath, remoteName string) error
+}
+
+// newUploadSink builds the UploadSink for a -sink URL: sftp://user@host/path,
+// s3://bucket/prefix, or http(s)://host/path (PUT).
+func newUploadSink(sinkURL string) (UploadSink, error) {
+	u, err := url.Parse(sinkURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -sink URL %q: %v", sinkURL, err)
+	}
+
+	switch u.Scheme {
+	case "sftp":
+		return newSFTPSink(u)
+	case "s3":
+		return newS3Sink(u)
+	case "http", "https":
+		return &httpPutSink{baseURL: sinkURL}, nil
+	default:
+		return nil, fmt.Errorf("unsupported -sink scheme %q (want sftp, s3, http, or https)", u.Scheme)
+	}
+}
+
+// withRetry calls fn up to attempts times, doubling a backoff (capped at 10s)
+// between failures, and returns the last error if every attempt fails.
+func withRetry(attempts int, fn func() error) error {
+	var err error
+	backoff := 500 * time.Millisecond
+	for i := 0; i < attempts; i++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if i == attempts-1 {
+			break
+		}
+		log.Printf("Upload attempt %d/%d failed, retrying in %v: %v\n", i+1, attempts, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > 10*time.Second {
+			backoff = 10 * time.Second
+		}
+	}
+	return err
+}
+
+// md5File returns the hex-encoded MD5 of the file at path, used to compare
+// against a single-part S3 upload's ETag.
+func md5File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// sftpSink uploads over SFTP, authenticating via a running ssh-agent or a
+// password embedded in the sink URL.
+type sftpSink struct {
+	addr     string
+	basePath string
+	config   *ssh.ClientConfig
+}
+
+func newSFTPSink(u *url.URL) (*sftpSink, error) {
+	addr := u.Host
+	if !strings.Contains(addr, ":") {
+		addr += ":22"
+	}
+	user := "root"
+	if u.User != nil {
+		user = u.User.Username()
+	}
+
+	authMethods, err := sftpAuthMethods(u)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sftpSink{
+		addr:     addr,
+		basePath: strings.TrimPrefix(u.Path, "/"),
+		config: &ssh.ClientConfig{
+			User:            user,
+			Auth:            authMethods,
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(), // mirror pushes run over trusted networks; no known_hosts plumbing here
+			Timeout:         30 * time.Second,
+		},
+	}, nil
+}
+
+// sftpAuthMethods prefers a running ssh-agent (SSH_AUTH_SOCK), the common
+// case for an operator shell or CI runner, and falls back to a password
+// embedded in the sink URL.
+func sftpAuthMethods(u *url.URL) ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+
+	if u.User != nil {
+		if pass, ok := u.User.Password(); ok {
+			methods = append(methods, ssh.Password(pass))
+		}
+	}
+
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("sftp: no auth available, set SSH_AUTH_SOCK or include a password in the -sink URL")
+	}
+	return methods, nil
+}
+
+func (s *sftpSink) Upload(localPath, remoteName string) error {
+	return withRetry(3, func() error { return s.uploadOnce(localPath, remoteName) })
+}
+
+func (s *sftpSink) uploadOnce(localPath, remoteName string) error {
+	conn, err := ssh.Dial("tcp", s.addr, s.config)
+	if err != nil {
+		return fmt.Errorf("sftp: dial %s: %v", s.addr, err)
+	}
+	defer conn.Close()
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		return fmt.Errorf("sftp: new client: %v", err)
+	}
+	defer client.Close()
+
+	local, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer local.Close()
+	localInfo, err := local.Stat()
+	if err != nil {
+		return err
+	}
+
+	remotePath := path.Join(s.basePath, remoteName)
+	if dir := path.Dir(remotePath); dir != "." {
+		_ = client.MkdirAll(dir)
+	}
+
+	remote, err := client.Create(remotePath)
+	if err != nil {
+		return fmt.Errorf("sftp: create %s: %v", remotePath, err)
+	}
+	written, copyErr := io.Copy(remote, local)
+	closeErr := remote.Close()
+	if copyErr != nil {
+		return fmt.Errorf("sftp: write %s: %v", remotePath, copyErr)
+	}
+	if closeErr != nil {
+		return fmt.Errorf("sftp: close %s: %v", remotePath, closeErr)
+	}
+	if written != localInfo.Size() {
+		return fmt.Errorf("sftp: short write to %s: wrote %d, expected %d", remotePath, written, localInfo.Size())
+	}
+
+	remoteInfo, err := client.Stat(remotePath)
+	if err != nil {
+		return fmt.Errorf("sftp: stat %s after upload: %v", remotePath, err)
+	}
+	if remoteInfo.Size() != localInfo.Size() {
+		return fmt.Errorf("sftp: size mismatch for %s: remote %d, local %d", remotePath, remoteInfo.Size(), localInfo.Size())
+	}
+	return nil
+}
+
+// s3Sink uploads to an S3 bucket/prefix using the ambient AWS credential
+// chain (environment, shared config, instance role, ...).
+type s3Sink struct {
+	bucket string
+	prefix string
+	client *s3.Client
+}
+
+func newS3Sink(u *url.URL) (*s3Sink, error) {
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("s3: loading AWS config: %v", err)
+	}
+	return &s3Sink{
+		bucket: u.Host,
+		prefix: strings.TrimPrefix(u.Path, "/"),
+		client: s3.NewFromConfig(cfg),
+	}, nil
+}
+
+func (s *s3Sink) Upload(localPath, remoteName string) error {
+	return withRetry(3, func() error { return s.uploadOnce(localPath, remoteName) })
+}
+
+func (s *s3Sink) uploadOnce(localPath, remoteName string) error {
+	local, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer local.Close()
+	localInfo, err := local.Stat()
+	if err != nil {
+		return err
+	}
+	localMD5, err := md5File(localPath)
+	if err != nil {
+		return err
+	}
+
+	key := path.Join(s.prefix, remoteName)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	if _, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   local,
+	}); err != nil {
+		return fmt.Errorf("s3: put %s/%s: %v", s.bucket, key, err)
+	}
+
+	head, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(key)})
+	if err != nil {
+		return fmt.Errorf("s3: head %s/%s after upload: %v", s.bucket, key, err)
+	}
+	if head.ContentLength == nil || *head.ContentLength != localInfo.Size() {
+		return fmt.Errorf("s3: size mismatch for %s/%s", s.bucket, key)
+	}
+	if head.ETag != nil {
+		etag := strings.Trim(*head.ETag, `"`)
+		if !strings.Contains(etag, "-") && etag != localMD5 {
+			return fmt.Errorf("s3: ETag mismatch for %s/%s: remote %s, local md5 %s", s.bucket, key, etag, localMD5)
+		}
+	}
+	return nil
+}
+
+// httpPutSink uploads by issuing a PUT to baseURL/remoteName, the simplest
+// sink a static file server can support.
+type httpPutSink struct {
+	baseURL string
+}
+
+func (s *httpPutSink) Upload(localPath, remoteName string) error {
+	return withRetry(3, func() error { return s.uploadOnce(localPath, remoteName) })
+}
+
+func (s *httpPutSink) uploadOnce(localPath, remoteName string) error {
+	local, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer local.Close()
+	info, err := local.Stat()
+	if err != nil {
+		return err
+	}
+
+	dest := strings.TrimRight(s.baseURL, "/") + "/" + remoteName
+	req, err := http.NewRequest(http.MethodPut, dest, local)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("http: PUT %s: %v", dest, err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("http: PUT %s: unexpected status %s", dest, resp.Status)
+	}
+
+	if head, err := http.Head(dest); err == nil {
+		head.Body.Close()
+		if head.ContentLength >= 0 && head.ContentLength != info.Size() {
+			return fmt.Errorf("http: size mismatch for %s: server reports %d, local %d", dest, head.ContentLength, info.Size())
+		}
+	}
+	return nil
+}
+
+// sinkManifest is the small JSON pointer file uploaded alongside the TOML,
+// archive, and signature so a mirror puller can discover and fetch the set
+// atomically instead of polling for individual files to appear.
+type sinkManifest struct {
+	Directory   string `json:"directory"`
+	TOML        string `json:"toml"`
+	Archive     string `json:"archive"`
+	Signature   string `json:"signature"`
+	GeneratedAt string `json:"generated_at"`
+}
+
+// uploadArtifacts pushes the TOML, archive, and detached signature to the
+// -sink destination, plus a manifest.json pointing at all three.
+func uploadArtifacts(sinkURL, dirName, tomlPath, archivePath, sigPath string) error {
+	sink, err := newUploadSink(sinkURL)
+	if err != nil {
+		return err
+	}
+
+	manifest := sinkManifest{
+		Directory:   dirName,
+		TOML:        filepath.Base(tomlPath),
+		Archive:     filepath.Base(archivePath),
+		Signature:   filepath.Base(sigPath),
+		GeneratedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	manifestBytes, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	manifestPath := filepath.Join(os.TempDir(), dirName+".manifest.json")
+	if err := os.WriteFile(manifestPath, manifestBytes, 0644); err != nil {
+		return err
+	}
+	defer os.Remove(manifestPath)
+
+	artifacts := []struct{ local, remote string }{
+		{tomlPath, manifest.TOML},
+		{archivePath, manifest.Archive},
+		{sigPath, manifest.Signature},
+		{manifestPath, "manifest.json"},
+	}
+
+	for _, a := range artifacts {
+		log.Printf("Uploading %s to sink...\n", a.remote)
+		if err := sink.Upload(a.local, a.remote); err != nil {
+			return fmt.Errorf("uploading %s: %v", a.remote, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,472 @@
+// =========================================================
+// Script Name: verify.go
+// Description: `verify` subcommand that validates a directory (or just its
+//              archive) against a previously generated TOML + embedded GPG
+//              signature.
+// =========================================================
+
+package main
+
+import (
+	"archive/zip"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+	"lukechampine.com/blake3"
+)
+
+// Exit codes for the verify subcommand, distinct so a caller can tell a bad
+// signature apart from missing files or a content mismatch.
+const (
+	exitSignatureFailure = 1
+	exitMissingFiles     = 2
+	exitHashMismatch     = 3
+)
+
+// tomlFileEntry is one [files."..."] table from a dir-hasher TOML.
+type tomlFileEntry struct {
+	Size     int64
+	Modified string
+	Digest   string
+}
+
+// hasherToml is the subset of a dir-hasher TOML that verify cares about.
+type hasherToml struct {
+	DirectoryName string
+	MerkleRoot    string
+	GPGKeyID      string
+	GPGSignature  string
+	GPGPublicKey  string
+	GPGSignedData string
+	Files         map[string]tomlFileEntry
+}
+
+// parseHasherToml parses the hand-written TOML dialect createTomlFile emits:
+// "key = value" lines grouped under "[section]" headers, with [files."..."]
+// tables repeated per file, and a handful of values stored as TOML literal
+// multi-line strings (triple single quotes). This is not a general TOML parser; it only
+// understands the shapes dir-hasher itself writes.
+func parseHasherToml(path string) (*hasherToml, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &hasherToml{Files: map[string]tomlFileEntry{}}
+	lines := strings.Split(string(data), "\n")
+	section := ""
+	currentFile := ""
+
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			header := strings.Trim(line, "[]")
+			if strings.HasPrefix(header, `files."`) {
+				currentFile = strings.TrimSuffix(strings.TrimPrefix(header, `files."`), `"`)
+				doc.Files[currentFile] = tomlFileEntry{}
+				section = "files"
+			} else {
+				section = header
+			}
+			continue
+		}
+
+		eq := strings.Index(line, "=")
+		if eq < 0 {
+			continue
+		}
+		key := strings.TrimSpace(line[:eq])
+		raw := strings.TrimSpace(line[eq+1:])
+
+		var value string
+		if strings.HasPrefix(raw, "'''") {
+			value, i = readLiteralString(raw, lines, i)
+		} else {
+			value = strings.Trim(raw, `"`)
+		}
+
+		switch section {
+		case "directory":
+			if key == "name" {
+				doc.DirectoryName = value
+			}
+		case "hashes":
+			if key == "merkle_root" {
+				doc.MerkleRoot = value
+			}
+		case "signature":
+			switch key {
+			case "gpg_key_id":
+				doc.GPGKeyID = value
+			case "gpg_signature":
+				doc.GPGSignature = value
+			case "gpg_public_key":
+				doc.GPGPublicKey = value
+			case "signed_data":
+				doc.GPGSignedData = value
+			}
+		case "files":
+			entry := doc.Files[currentFile]
+			switch key {
+			case "size":
+				entry.Size, _ = strconv.ParseInt(value, 10, 64)
+			case "modified":
+				entry.Modified = value
+			case "digest":
+				entry.Digest = value
+			}
+			doc.Files[currentFile] = entry
+		}
+	}
+
+	return doc, nil
+}
+
+// readLiteralString consumes a TOML literal string starting at raw (the
+// trimmed remainder of the "key = " line), following continuation lines
+// until the closing triple quote. It returns the string's content and the index of
+// the last line it consumed, so the caller's loop can resume after it.
+func readLiteralString(raw string, lines []string, i int) (string, int) {
+	rest := strings.TrimPrefix(raw, "'''")
+	if idx := strings.Index(rest, "'''"); idx >= 0 {
+		return rest[:idx], i
+	}
+
+	var b strings.Builder
+	b.WriteString(rest)
+	for i++; i < len(lines); i++ {
+		if idx := strings.Index(lines[i], "'''"); idx >= 0 {
+			b.WriteString("\n")
+			b.WriteString(lines[i][:idx])
+			return strings.TrimPrefix(b.String(), "\n"), i
+		}
+		b.WriteString("\n")
+		b.WriteString(lines[i])
+	}
+	return strings.TrimPrefix(b.String(), "\n"), i
+}
+
+// verifyTomlSignature checks doc's embedded GPG signature over its embedded
+// signed_data, using keyringPath if given or else the public key embedded in
+// the TOML itself.
+func verifyTomlSignature(doc *hasherToml, keyringPath string) error {
+	keyringData := doc.GPGPublicKey
+	if keyringPath != "" {
+		data, err := os.ReadFile(keyringPath)
+		if err != nil {
+			return fmt.Errorf("reading keyring: %v", err)
+		}
+		keyringData = string(data)
+	}
+	if keyringData == "" {
+		return fmt.Errorf("no -keyring given and TOML has no embedded gpg_public_key")
+	}
+	if doc.GPGSignedData == "" {
+		return fmt.Errorf("TOML has no embedded signed_data to check the signature against")
+	}
+
+	keyring, err := openpgp.ReadArmoredKeyRing(strings.NewReader(keyringData))
+	if err != nil {
+		return fmt.Errorf("parsing keyring: %v", err)
+	}
+
+	_, err = openpgp.CheckArmoredDetachedSignature(keyring, strings.NewReader(doc.GPGSignedData), strings.NewReader(doc.GPGSignature))
+	if err != nil {
+		return fmt.Errorf("signature check failed: %v", err)
+	}
+	return nil
+}
+
+// verifyEntry is one file or directory found while walking the thing being
+// verified, carrying what recomputeMerkleRoot needs to rebuild the same tree
+// buildMerkleTree would have produced from this content. Directories must be
+// included explicitly, even empty ones: buildMerkleTree gives every
+// directory in the inventory its own node, and an empty directory still
+// contributes a line to its parent's serialization.
+type verifyEntry struct {
+	relPath string // "/"-separated, relative to the root being verified
+	isDir   bool
+	mode    uint32
+	size    int64
+	digest  string
+}
+
+// recomputeMerkleRoot rebuilds the canonical Merkle root buildMerkleTree (see
+// merkle.go) would compute from a flat list of files/directories and their
+// freshly computed digests. verify uses this to confirm the *actual*
+// directory or archive content was what got signed, rather than only
+// trusting the TOML's own [files] table, which an attacker who edits a file
+// could edit to match.
+func recomputeMerkleRoot(entries []verifyEntry) string {
+	type node struct {
+		isDir    bool
+		mode     uint32
+		size     int64
+		digest   string
+		children map[string]*node
+	}
+	root := &node{isDir: true, children: map[string]*node{}}
+
+	for _, e := range entries {
+		segs := strings.Split(strings.Trim(e.relPath, "/"), "/")
+		cur := root
+		for i, seg := range segs {
+			last := i == len(segs)-1
+			child, ok := cur.children[seg]
+			if !ok {
+				child = &node{isDir: true, children: map[string]*node{}}
+				cur.children[seg] = child
+			}
+			if last && !e.isDir {
+				child.isDir = false
+				child.mode = e.mode
+				child.size = e.size
+				child.digest = e.digest
+			}
+			cur = child
+		}
+	}
+
+	var digestNode func(n *node) string
+	digestNode = func(n *node) string {
+		if !n.isDir {
+			return n.digest
+		}
+
+		names := make([]string, 0, len(n.children))
+		for name := range n.children {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		var dirEntries []dirEntryDigest
+		for _, name := range names {
+			child := n.children[name]
+			dirEntries = append(dirEntries, dirEntryDigest{
+				Name:        name,
+				Mode:        child.mode, // directories carry Mode 0 here, matching buildMerkleTree
+				Size:        child.size,
+				ChildDigest: digestNode(child),
+			})
+		}
+		return blake3Sum(serializeDirEntries(dirEntries))
+	}
+
+	return digestNode(root)
+}
+
+// signedBlake3Root extracts the "BLAKE3: <hex>" line from signedData, the
+// dataToSign text generateDirectoryHashes built and signed (see
+// dir-hasher.go). That value is exactly the Merkle root digest at signing
+// time, so comparing it against a fresh recomputeMerkleRoot result ties the
+// signature to the content actually being verified, not just to the TOML's
+// own [files] table.
+func signedBlake3Root(signedData string) (string, error) {
+	for _, line := range strings.Split(signedData, "\n") {
+		if strings.HasPrefix(line, "BLAKE3: ") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "BLAKE3: ")), nil
+		}
+	}
+	return "", fmt.Errorf("signed_data has no BLAKE3 line")
+}
+
+// verifyDirectory re-hashes every regular file under dirPath and compares it
+// against doc.Files, returning files whose digest differs, files doc.Files
+// lists but dirPath doesn't have, files dirPath has but doc.Files doesn't
+// list, and the Merkle root recomputed from what's actually on disk.
+func verifyDirectory(dirPath string, doc *hasherToml) (mismatches, missing, extra []string, root string, err error) {
+	seen := map[string]bool{}
+	var entries []verifyEntry
+
+	walkErr := filepath.Walk(dirPath, func(p string, info os.FileInfo, walkErr error) error {
+		if walkErr != nil {
+			return nil
+		}
+		rel, relErr := filepath.Rel(dirPath, p)
+		if relErr != nil {
+			rel = p
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			return nil // dirPath itself isn't a node; only its contents are
+		}
+
+		if info.IsDir() {
+			entries = append(entries, verifyEntry{relPath: rel, isDir: true})
+			return nil
+		}
+		seen[rel] = true
+
+		digest, hashErr := blake3File(p)
+		if hashErr != nil {
+			return hashErr
+		}
+		entries = append(entries, verifyEntry{relPath: rel, mode: uint32(info.Mode()), size: info.Size(), digest: digest})
+
+		expected, ok := doc.Files[rel]
+		if !ok {
+			extra = append(extra, rel)
+			return nil
+		}
+		if digest != expected.Digest {
+			mismatches = append(mismatches, rel)
+		}
+		return nil
+	})
+	if walkErr != nil {
+		return nil, nil, nil, "", walkErr
+	}
+
+	for rel := range doc.Files {
+		if !seen[rel] {
+			missing = append(missing, rel)
+		}
+	}
+
+	sort.Strings(mismatches)
+	sort.Strings(missing)
+	sort.Strings(extra)
+	return mismatches, missing, extra, recomputeMerkleRoot(entries), nil
+}
+
+// verifyZipArchive streams every entry out of a zip archive (without
+// extracting it to disk) and compares it against doc.Files the same way
+// verifyDirectory does for a directory, also returning the Merkle root
+// recomputed from the archive's actual contents.
+func verifyZipArchive(zipPath string, doc *hasherToml) (mismatches, missing, extra []string, root string, err error) {
+	zr, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return nil, nil, nil, "", err
+	}
+	defer zr.Close()
+
+	seen := map[string]bool{}
+	var entries []verifyEntry
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			entries = append(entries, verifyEntry{relPath: strings.TrimSuffix(f.Name, "/"), isDir: true})
+			continue
+		}
+		name := f.Name
+		seen[name] = true
+
+		rc, openErr := f.Open()
+		if openErr != nil {
+			return nil, nil, nil, "", fmt.Errorf("opening %s in archive: %v", name, openErr)
+		}
+		hasher := blake3.New(32, nil)
+		_, copyErr := io.Copy(hasher, rc)
+		rc.Close()
+		if copyErr != nil {
+			return nil, nil, nil, "", fmt.Errorf("reading %s from archive: %v", name, copyErr)
+		}
+		digest := hex.EncodeToString(hasher.Sum(nil))
+		entries = append(entries, verifyEntry{relPath: name, mode: uint32(f.Mode()), size: int64(f.UncompressedSize64), digest: digest})
+
+		expected, ok := doc.Files[name]
+		if !ok {
+			extra = append(extra, name)
+			continue
+		}
+		if digest != expected.Digest {
+			mismatches = append(mismatches, name)
+		}
+	}
+
+	for rel := range doc.Files {
+		if !seen[rel] {
+			missing = append(missing, rel)
+		}
+	}
+
+	sort.Strings(mismatches)
+	sort.Strings(missing)
+	sort.Strings(extra)
+	return mismatches, missing, extra, recomputeMerkleRoot(entries), nil
+}
+
+// verifyMain implements `dir-hasher verify`, parsing its own flag set from
+// args (os.Args[2:]) since the top-level flags in init() don't apply here.
+func verifyMain(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	tomlPath := fs.String("toml", "", "Path to the TOML file produced by a previous hashing run")
+	dirArg := fs.String("dir", "", "Directory to verify against the TOML")
+	zipArg := fs.String("zip", "", "Zip archive to verify against the TOML instead of -dir")
+	keyringPath := fs.String("keyring", "", "Path to an armored public keyring; defaults to the key embedded in the TOML")
+	fs.Parse(args)
+
+	if *tomlPath == "" {
+		log.Fatal("Error: verify requires -toml")
+	}
+	if *dirArg == "" && *zipArg == "" {
+		log.Fatal("Error: verify requires -dir or -zip")
+	}
+
+	doc, err := parseHasherToml(*tomlPath)
+	if err != nil {
+		log.Fatalf("Error parsing TOML %s: %v\n", *tomlPath, err)
+	}
+
+	if err := verifyTomlSignature(doc, *keyringPath); err != nil {
+		log.Printf("Signature invalid: %v\n", err)
+		os.Exit(exitSignatureFailure)
+	}
+	log.Println("Signature OK")
+
+	expectedRoot, err := signedBlake3Root(doc.GPGSignedData)
+	if err != nil {
+		log.Printf("Cannot confirm provenance: %v\n", err)
+		os.Exit(exitSignatureFailure)
+	}
+
+	var mismatches, missing, extra []string
+	var actualRoot string
+	if *zipArg != "" {
+		mismatches, missing, extra, actualRoot, err = verifyZipArchive(*zipArg, doc)
+	} else {
+		mismatches, missing, extra, actualRoot, err = verifyDirectory(*dirArg, doc)
+	}
+	if err != nil {
+		log.Fatalf("Error verifying contents: %v\n", err)
+	}
+
+	if actualRoot != expectedRoot {
+		log.Printf("ROOT MISMATCH: recomputed Merkle root %s does not match the signed root %s\n", actualRoot, expectedRoot)
+		os.Exit(exitHashMismatch)
+	}
+	log.Println("Merkle root matches the signed value")
+
+	for _, name := range extra {
+		log.Printf("EXTRA (not in TOML): %s\n", name)
+	}
+	for _, name := range missing {
+		log.Printf("MISSING: %s\n", name)
+	}
+	for _, name := range mismatches {
+		log.Printf("HASH MISMATCH: %s\n", name)
+	}
+
+	switch {
+	case len(mismatches) > 0:
+		log.Printf("Verification FAILED: %d hash mismatch(es)\n", len(mismatches))
+		os.Exit(exitHashMismatch)
+	case len(missing) > 0:
+		log.Printf("Verification FAILED: %d missing file(s)\n", len(missing))
+		os.Exit(exitMissingFiles)
+	default:
+		log.Printf("Verification OK: %d files matched (%d extra untracked)\n", len(doc.Files), len(extra))
+	}
+}